@@ -0,0 +1,255 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Consumer is returned by Relay.Consumer and is used to consume
+// messages from a queue. Unless Config.EnableMultiAck is set, the
+// previously consumed message must be Ack'd or Nack'd before the
+// next one can be consumed.
+type Consumer struct {
+	conf  *Config
+	relay *Relay
+	tag   string
+	spec  QueueSpec
+
+	mu          sync.Mutex
+	channel     *amqp.Channel
+	deliver     <-chan amqp.Delivery
+	reconnected chan struct{} // closed and replaced each time channel/deliver are swapped in
+	giveUp      bool          // set when AutoReconnect has permanently given up, so a final close of reconnected doesn't get mistaken for a successful rebuild
+	closed      bool
+
+	lastTag      uint64
+	lastDelivery amqp.Delivery
+	pending      bool
+}
+
+// Consume blocks until a message is available and decodes it into out.
+func (c *Consumer) Consume(out interface{}) error {
+	_, err := c.consume(out, nil, nil)
+	return err
+}
+
+// ConsumeId behaves like Consume but also returns the delivery tag
+// of the message.
+func (c *Consumer) ConsumeId(out interface{}) (uint64, error) {
+	return c.consume(out, nil, nil)
+}
+
+// ConsumeAck behaves like Consume, but automatically acknowledges
+// the message once it has been decoded.
+func (c *Consumer) ConsumeAck(out interface{}) error {
+	if err := c.Consume(out); err != nil {
+		return err
+	}
+	return c.Ack()
+}
+
+// ConsumeTimeout behaves like Consume, but returns an error if no
+// message arrives within the given timeout.
+func (c *Consumer) ConsumeTimeout(out interface{}, timeout time.Duration) error {
+	_, err := c.consume(out, time.After(timeout), nil)
+	return err
+}
+
+// ConsumeTimeoutId behaves like ConsumeId, but returns an error if no
+// message arrives within the given timeout.
+func (c *Consumer) ConsumeTimeoutId(out interface{}, timeout time.Duration) (uint64, error) {
+	return c.consume(out, time.After(timeout), nil)
+}
+
+// ConsumeCtx behaves like Consume, but returns ctx.Err() if ctx is done
+// before a message arrives. No message is consumed in that case, so the
+// next delivery is left for redelivery rather than lost.
+func (c *Consumer) ConsumeCtx(ctx context.Context, out interface{}) error {
+	_, err := c.consume(out, nil, ctx)
+	return err
+}
+
+// ConsumeAckCtx behaves like ConsumeCtx, but automatically acknowledges
+// the message once it has been decoded.
+func (c *Consumer) ConsumeAckCtx(ctx context.Context, out interface{}) error {
+	if err := c.ConsumeCtx(ctx, out); err != nil {
+		return err
+	}
+	return c.Ack()
+}
+
+func (c *Consumer) consume(out interface{}, timeoutCh <-chan time.Time, ctx context.Context) (uint64, error) {
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	for {
+		c.mu.Lock()
+		if c.closed || c.giveUp {
+			c.mu.Unlock()
+			return 0, ChannelClosed
+		}
+		if c.pending && !c.conf.EnableMultiAck {
+			c.mu.Unlock()
+			return 0, fmt.Errorf("Ack required before consume!")
+		}
+		deliver := c.deliver
+		reconnected := c.reconnected
+		c.mu.Unlock()
+
+		select {
+		case d, ok := <-deliver:
+			if !ok {
+				// The channel behind us died. If we're set up to
+				// transparently reconnect, wait for a fresh delivery
+				// stream instead of surfacing the error.
+				if !c.conf.AutoReconnect {
+					return 0, ChannelClosed
+				}
+				select {
+				case <-reconnected:
+					c.mu.Lock()
+					giveUp := c.giveUp
+					c.mu.Unlock()
+					if giveUp {
+						return 0, ChannelClosed
+					}
+					continue
+				case <-timeoutCh:
+					return 0, fmt.Errorf("Timed out waiting for a message!")
+				case <-done:
+					return 0, ctx.Err()
+				}
+			}
+
+			if err := decodeDelivery(c.conf.Serializer, d, out); err != nil {
+				return 0, fmt.Errorf("Failed to decode message! Got: %s", err)
+			}
+
+			c.mu.Lock()
+			c.lastTag = d.DeliveryTag
+			c.lastDelivery = d
+			c.pending = true
+			c.mu.Unlock()
+			return d.DeliveryTag, nil
+
+		case <-timeoutCh:
+			return 0, fmt.Errorf("Timed out waiting for a message!")
+
+		case <-reconnected:
+			continue
+
+		case <-done:
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Ack acknowledges the most recently consumed message. If
+// Config.EnableMultiAck is set, this also acknowledges every
+// message consumed since the last Ack or Nack.
+func (c *Consumer) Ack() error {
+	c.mu.Lock()
+	ch, tag, multi := c.channel, c.lastTag, c.conf.EnableMultiAck
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return ChannelClosed
+	}
+
+	if err := ch.Ack(tag, multi); err != nil {
+		if err == amqp.ErrClosed {
+			return ChannelClosed
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	c.pending = false
+	c.mu.Unlock()
+	return nil
+}
+
+// Nack negatively acknowledges the most recently consumed message,
+// requeueing it (and, if Config.EnableMultiAck is set, every message
+// consumed since the last Ack or Nack) for redelivery. If
+// Config.DeadLetter is set and the message's delivery count (per its
+// x-death header) has exceeded DeadLetter.MaxDeliveries, Nack instead
+// Acks just that message and republishes it to the dead-letter queue,
+// to break poison-message loops; under Config.EnableMultiAck, every
+// earlier message in the same batch is still requeued via the normal
+// multi-Nack path rather than being swept up by the dead-letter Ack.
+func (c *Consumer) Nack() error {
+	c.mu.Lock()
+	ch, tag, multi := c.channel, c.lastTag, c.conf.EnableMultiAck
+	closed := c.closed
+	dl := c.conf.DeadLetter
+	d := c.lastDelivery
+	c.mu.Unlock()
+	if closed {
+		return ChannelClosed
+	}
+
+	if dl != nil && dl.MaxDeliveries > 0 && deliveryCount(d) >= dl.MaxDeliveries {
+		if err := c.deadLetter(ch, tag, d); err != nil {
+			return err
+		}
+		if multi && tag > 1 {
+			if err := ch.Nack(tag-1, true, true); err != nil {
+				if err == amqp.ErrClosed {
+					return ChannelClosed
+				}
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := ch.Nack(tag, multi, true); err != nil {
+		if err == amqp.ErrClosed {
+			return ChannelClosed
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	c.pending = false
+	c.mu.Unlock()
+	return nil
+}
+
+// Close will shutdown the consumer. Any pending, un-Ack'd message is
+// Nack'd so that it is redelivered to another consumer.
+func (c *Consumer) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	ch, tag, multi, pending := c.channel, c.lastTag, c.conf.EnableMultiAck, c.pending
+	c.pending = false
+	// Wake any consume() blocked waiting on a reconnect (AutoReconnect's
+	// delivery channel died and it's waiting on <-reconnected) so it
+	// rechecks c.closed and returns ChannelClosed, rather than hanging
+	// forever on a signal that's only ever fired by a successful or
+	// exhausted reconnect.
+	close(c.reconnected)
+	c.mu.Unlock()
+
+	if c.relay != nil {
+		c.relay.deregisterConsumer(c)
+	}
+
+	if ch == nil {
+		return nil
+	}
+	if pending {
+		ch.Nack(tag, multi, true)
+	}
+	return ch.Close()
+}
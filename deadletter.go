@@ -0,0 +1,114 @@
+package relay
+
+import (
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// deadLetterExchangeName returns the dead-letter exchange configured
+// for dl, defaulting to "<relay-exchange>.dlx".
+func deadLetterExchangeName(conf *Config, dl *DeadLetterConfig) string {
+	if dl.Exchange != "" {
+		return dl.Exchange
+	}
+	return conf.Exchange + ".dlx"
+}
+
+// deadLetterQueueName returns the dead-letter queue name for queue,
+// defaulting to "<queue>.dlq".
+func deadLetterQueueName(queue string, dl *DeadLetterConfig) string {
+	suffix := dl.QueueSuffix
+	if suffix == "" {
+		suffix = ".dlq"
+	}
+	return queue + suffix
+}
+
+// declareDeadLetter ensures the dead-letter exchange and queue for name
+// exist, with the DLQ bound using name as its routing key so messages
+// dead-lettered off of name land there.
+func (r *Relay) declareDeadLetter(ch *amqp.Channel, name string, dl *DeadLetterConfig) error {
+	exchange := deadLetterExchangeName(r.conf, dl)
+	dlQueue := deadLetterQueueName(name, dl)
+
+	if err := ch.ExchangeDeclare(exchange, "direct", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("Failed to declare dead-letter exchange '%s'! Got: %s", exchange, err)
+	}
+	if _, err := ch.QueueDeclare(dlQueue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("Failed to declare dead-letter queue '%s'! Got: %s", dlQueue, err)
+	}
+	if err := ch.QueueBind(dlQueue, name, exchange, false, nil); err != nil {
+		return fmt.Errorf("Failed to bind dead-letter queue '%s'! Got: %s", dlQueue, err)
+	}
+	return nil
+}
+
+// deliveryCount returns how many times d has previously been delivered,
+// as reported by the broker's x-death header. It is 0 for a message's
+// first delivery or if no x-death header is present.
+func deliveryCount(d amqp.Delivery) int {
+	raw, ok := d.Headers["x-death"]
+	if !ok {
+		return 0
+	}
+	deaths, ok := raw.([]interface{})
+	if !ok {
+		return 0
+	}
+
+	var total int64
+	for _, entry := range deaths {
+		table, ok := entry.(amqp.Table)
+		if !ok {
+			continue
+		}
+		if count, ok := table["count"].(int64); ok {
+			total += count
+		}
+	}
+	return int(total)
+}
+
+// DeliveryCount returns how many times the most recently consumed
+// message has previously been delivered, based on its x-death header.
+// It is 0 for a message's first delivery. User code can use this to
+// make dead-lettering decisions explicitly, in addition to the
+// automatic handling Nack performs when Config.DeadLetter is set.
+func (c *Consumer) DeliveryCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return deliveryCount(c.lastDelivery)
+}
+
+// deadLetter republishes d to its dead-letter queue and Acks only tag
+// (never multi=true), used by Nack once
+// Config.DeadLetter.MaxDeliveries has been exceeded. Acking just tag
+// matters under Config.EnableMultiAck: d is the only delivery actually
+// republished to the DLQ, so any earlier pending deliveries in the same
+// batch must be left for the normal multi-Nack path to requeue, rather
+// than being silently acked and dropped alongside it.
+func (c *Consumer) deadLetter(ch *amqp.Channel, tag uint64, d amqp.Delivery) error {
+	dl := c.conf.DeadLetter
+	exchange := deadLetterExchangeName(c.conf, dl)
+
+	msg := amqp.Publishing{Headers: d.Headers, ContentType: d.ContentType, DeliveryMode: d.DeliveryMode, Body: d.Body}
+	if err := ch.Publish(exchange, c.spec.Name, false, false, msg); err != nil {
+		if err == amqp.ErrClosed {
+			return ChannelClosed
+		}
+		return fmt.Errorf("Failed to publish dead-lettered message! Got: %s", err)
+	}
+
+	if err := ch.Ack(tag, false); err != nil {
+		if err == amqp.ErrClosed {
+			return ChannelClosed
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	c.pending = false
+	c.mu.Unlock()
+	return nil
+}
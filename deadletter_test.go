@@ -0,0 +1,58 @@
+package relay
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestDeliveryCountNoXDeath(t *testing.T) {
+	d := amqp.Delivery{}
+	if got := deliveryCount(d); got != 0 {
+		t.Fatalf("expected 0, got %d", got)
+	}
+}
+
+func TestDeliveryCountSumsXDeathEntries(t *testing.T) {
+	d := amqp.Delivery{
+		Headers: amqp.Table{
+			"x-death": []interface{}{
+				amqp.Table{"count": int64(2), "reason": "rejected"},
+				amqp.Table{"count": int64(3), "reason": "expired"},
+			},
+		},
+	}
+	if got := deliveryCount(d); got != 5 {
+		t.Fatalf("expected 5, got %d", got)
+	}
+}
+
+func TestDeliveryCountIgnoresMalformedHeader(t *testing.T) {
+	d := amqp.Delivery{Headers: amqp.Table{"x-death": "not a list"}}
+	if got := deliveryCount(d); got != 0 {
+		t.Fatalf("expected 0, got %d", got)
+	}
+
+	d = amqp.Delivery{Headers: amqp.Table{"x-death": []interface{}{"not a table"}}}
+	if got := deliveryCount(d); got != 0 {
+		t.Fatalf("expected 0, got %d", got)
+	}
+}
+
+func TestDeadLetterExchangeAndQueueNames(t *testing.T) {
+	conf := &Config{Exchange: "relay"}
+
+	if got := deadLetterExchangeName(conf, &DeadLetterConfig{}); got != "relay.dlx" {
+		t.Fatalf("unexpected default dead-letter exchange %q", got)
+	}
+	if got := deadLetterExchangeName(conf, &DeadLetterConfig{Exchange: "custom.dlx"}); got != "custom.dlx" {
+		t.Fatalf("unexpected custom dead-letter exchange %q", got)
+	}
+
+	if got := deadLetterQueueName("orders", &DeadLetterConfig{}); got != "orders.dlq" {
+		t.Fatalf("unexpected default dead-letter queue %q", got)
+	}
+	if got := deadLetterQueueName("orders", &DeadLetterConfig{QueueSuffix: ".dead"}); got != "orders.dead" {
+		t.Fatalf("unexpected custom dead-letter queue %q", got)
+	}
+}
@@ -0,0 +1,307 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Publisher is returned by Relay.Publisher and is used to publish
+// messages to a queue.
+type Publisher struct {
+	conf        *Config
+	relay       *Relay
+	spec        QueueSpec
+	contentType string
+	mode        uint8
+
+	mu          sync.Mutex
+	channel     *amqp.Channel
+	ackCh       chan uint64
+	nackCh      chan uint64
+	errCh       chan *amqp.Error
+	reconnected chan struct{} // closed and replaced each time channel is swapped in
+	closed      bool
+
+	confirmMu  sync.Mutex
+	confirmTag uint64
+	generation uint64                // bumped each startConfirmLoop, so a superseded channel's late signals are dropped
+	pending    map[uint64]chan error // delivery tag -> caller's PublishAsync result channel
+}
+
+// Publish serializes in using the configured Serializer and publishes
+// it to the queue. Unless Config.DisablePublishConfirm is set, Publish
+// blocks until the broker confirms the message. If Config.AutoReconnect
+// is set and a reconnect is in progress, Publish blocks (up to
+// Config.ReconnectPublishTimeout) for the reconnect to finish rather
+// than failing immediately.
+func (p *Publisher) Publish(in interface{}) error {
+	resultCh, err := p.PublishAsync(in)
+	if err != nil {
+		return err
+	}
+	return <-resultCh
+}
+
+// PublishCtx behaves like Publish, but returns ctx.Err() if ctx is done
+// before the publish is confirmed (or, lacking confirms, before it's
+// written to the channel).
+func (p *Publisher) PublishCtx(ctx context.Context, in interface{}) error {
+	resultCh, err := p.publishAsync(in, ctx)
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-resultCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// PublishAsync serializes and publishes in, returning as soon as the
+// message has been written to the channel rather than waiting for the
+// broker to confirm it. The returned channel receives exactly one
+// result (nil on ack, an error otherwise) and is then closed; callers
+// that don't care about individual confirms can still use it to build a
+// fan-in, as PublishBatch does.
+func (p *Publisher) PublishAsync(in interface{}) (<-chan error, error) {
+	return p.publishAsync(in, nil)
+}
+
+func (p *Publisher) publishAsync(in interface{}, ctx context.Context) (<-chan error, error) {
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	body, err := p.conf.Serializer.Encode(in)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode message! Got: %s", err)
+	}
+	msg := amqp.Publishing{
+		ContentType:  p.contentType,
+		DeliveryMode: p.mode,
+		Body:         body,
+	}
+
+	var deadline time.Time
+	if p.conf.AutoReconnect {
+		timeout := p.conf.ReconnectPublishTimeout
+		if timeout <= 0 {
+			timeout = defaultReconnectPublishTimeout
+		}
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ChannelClosed
+		}
+		ch, ackCh, reconnected := p.channel, p.ackCh, p.reconnected
+		p.mu.Unlock()
+
+		if done != nil {
+			select {
+			case <-done:
+				return nil, ctx.Err()
+			default:
+			}
+		}
+
+		// Reserve our delivery tag before publishing, since a confirm
+		// can race back to us before Publish even returns.
+		var tag uint64
+		var resultCh chan error
+		if ackCh != nil {
+			resultCh = make(chan error, 1)
+			p.confirmMu.Lock()
+			p.confirmTag++
+			tag = p.confirmTag
+			p.pending[tag] = resultCh
+			p.confirmMu.Unlock()
+		}
+
+		if err := ch.Publish(p.spec.Exchange, p.spec.RoutingKey, false, false, msg); err != nil {
+			if ackCh != nil {
+				p.confirmMu.Lock()
+				delete(p.pending, tag)
+				p.confirmMu.Unlock()
+			}
+			if err != amqp.ErrClosed {
+				return nil, fmt.Errorf("Failed to publish message! Got: %s", err)
+			}
+			if retry, rerr := p.awaitReconnect(reconnected, deadline, done, ctx); !retry {
+				return nil, rerr
+			}
+			continue
+		}
+
+		if ackCh == nil {
+			resultCh = make(chan error, 1)
+			resultCh <- nil
+			close(resultCh)
+		}
+		return resultCh, nil
+	}
+}
+
+// PublishBatch pipelines the publish of every message in msgs, then
+// waits for all of them to be confirmed with a single fan-in. This
+// unlocks the throughput gains confirm-mode pipelining has over
+// publishing (and waiting on) one message at a time via Publish.
+func (p *Publisher) PublishBatch(msgs []interface{}) error {
+	resultChs := make([]<-chan error, 0, len(msgs))
+	for _, m := range msgs {
+		resultCh, err := p.PublishAsync(m)
+		if err != nil {
+			return err
+		}
+		resultChs = append(resultChs, resultCh)
+	}
+
+	for _, resultCh := range resultChs {
+		if err := <-resultCh; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// awaitReconnect blocks until a new channel has been swapped in, the
+// deadline passes, ctx is done, or auto-reconnect isn't enabled at all.
+// It reports whether the caller should retry the publish.
+func (p *Publisher) awaitReconnect(reconnected chan struct{}, deadline time.Time, done <-chan struct{}, ctx context.Context) (bool, error) {
+	if !p.conf.AutoReconnect {
+		return false, ChannelClosed
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return false, ChannelClosed
+	}
+
+	select {
+	case <-reconnected:
+		return true, nil
+	case <-time.After(remaining):
+		return false, fmt.Errorf("Timed out waiting for reconnect before publish!")
+	case <-done:
+		return false, ctx.Err()
+	}
+}
+
+// startConfirmLoop (re)starts the background goroutine that drains
+// publish confirmations and dispatches them to pending PublishAsync
+// callers. It must be called whenever a fresh channel (and therefore a
+// fresh set of confirm listeners) is put into place, including after a
+// reconnect. Bumping generation lets a late signal from the channel it
+// replaces (delivery tags restart at 1 after every reconnect, so a
+// stale tag colliding with a brand-new one is the common case) be
+// recognized as stale and dropped instead of resolving the wrong
+// publish.
+func (p *Publisher) startConfirmLoop() {
+	p.mu.Lock()
+	ackCh, nackCh, errCh := p.ackCh, p.nackCh, p.errCh
+	p.mu.Unlock()
+
+	p.confirmMu.Lock()
+	p.generation++
+	gen := p.generation
+	p.confirmTag = 0
+	p.pending = make(map[uint64]chan error)
+	p.confirmMu.Unlock()
+
+	if ackCh == nil {
+		return
+	}
+	go p.runConfirmLoop(gen, ackCh, nackCh, errCh)
+}
+
+func (p *Publisher) runConfirmLoop(gen uint64, ackCh, nackCh chan uint64, errCh chan *amqp.Error) {
+	for {
+		select {
+		case tag, ok := <-ackCh:
+			if !ok {
+				return
+			}
+			p.resolvePending(gen, tag, nil)
+		case tag, ok := <-nackCh:
+			if !ok {
+				return
+			}
+			p.resolvePending(gen, tag, fmt.Errorf("Broker nack'd published message!"))
+		case _, ok := <-errCh:
+			if !ok {
+				return
+			}
+			p.failPending(gen, ChannelClosed)
+			return
+		}
+	}
+}
+
+// resolvePending resolves every still-pending publish up to and
+// including tag, provided gen is still the current generation. Walking
+// the whole range (rather than just tag) is what correctly handles the
+// broker's "multiple" flag, where one ack/nack confirms every earlier
+// unconfirmed delivery tag at once.
+func (p *Publisher) resolvePending(gen, tag uint64, err error) {
+	p.confirmMu.Lock()
+	defer p.confirmMu.Unlock()
+	if gen != p.generation {
+		return
+	}
+	for t, resultCh := range p.pending {
+		if t <= tag {
+			resultCh <- err
+			close(resultCh)
+			delete(p.pending, t)
+		}
+	}
+}
+
+// failPending resolves every still-pending publish with err, provided
+// gen is still the current generation. Used when the channel behind
+// them has gone away for good.
+func (p *Publisher) failPending(gen uint64, err error) {
+	p.confirmMu.Lock()
+	defer p.confirmMu.Unlock()
+	if gen != p.generation {
+		return
+	}
+	for t, resultCh := range p.pending {
+		resultCh <- err
+		close(resultCh)
+		delete(p.pending, t)
+	}
+}
+
+// Close will shutdown the publisher.
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	ch := p.channel
+	p.mu.Unlock()
+
+	p.confirmMu.Lock()
+	gen := p.generation
+	p.confirmMu.Unlock()
+	p.failPending(gen, ChannelClosed)
+
+	if p.relay != nil {
+		p.relay.deregisterPublisher(p)
+	}
+	if ch == nil {
+		return nil
+	}
+	return ch.Close()
+}
@@ -0,0 +1,104 @@
+package relay
+
+import "testing"
+
+func newTestPublisher() *Publisher {
+	return &Publisher{pending: make(map[uint64]chan error)}
+}
+
+func TestResolvePendingAcksEveryTagUpToAndIncluding(t *testing.T) {
+	p := newTestPublisher()
+
+	results := make(map[uint64]<-chan error)
+	for tag := uint64(1); tag <= 3; tag++ {
+		ch := make(chan error, 1)
+		p.pending[tag] = ch
+		results[tag] = ch
+	}
+
+	// Broker acks with "multiple", confirming every earlier tag at once.
+	p.resolvePending(p.generation, 2, nil)
+
+	for tag := uint64(1); tag <= 2; tag++ {
+		select {
+		case err := <-results[tag]:
+			if err != nil {
+				t.Fatalf("tag %d: unexpected err %s", tag, err)
+			}
+		default:
+			t.Fatalf("tag %d: expected resultCh to be resolved", tag)
+		}
+	}
+	if _, ok := p.pending[1]; ok {
+		t.Fatalf("tag 1 should have been removed from pending")
+	}
+	if _, ok := p.pending[2]; ok {
+		t.Fatalf("tag 2 should have been removed from pending")
+	}
+	if _, ok := p.pending[3]; !ok {
+		t.Fatalf("tag 3 should still be pending")
+	}
+}
+
+func TestResolvePendingDropsStaleGeneration(t *testing.T) {
+	p := newTestPublisher()
+	p.generation = 2
+
+	ch := make(chan error, 1)
+	p.pending[1] = ch
+
+	// A late signal from a superseded (generation 1) channel must not
+	// touch the current generation's pending map.
+	p.resolvePending(1, 1, nil)
+
+	select {
+	case <-ch:
+		t.Fatalf("expected resultCh to be untouched by a stale-generation signal")
+	default:
+	}
+	if _, ok := p.pending[1]; !ok {
+		t.Fatalf("tag 1 should still be pending")
+	}
+}
+
+func TestFailPendingResolvesEveryPendingTag(t *testing.T) {
+	p := newTestPublisher()
+
+	chs := make([]chan error, 3)
+	for i := range chs {
+		chs[i] = make(chan error, 1)
+		p.pending[uint64(i+1)] = chs[i]
+	}
+
+	p.failPending(p.generation, ChannelClosed)
+
+	for i, ch := range chs {
+		select {
+		case err := <-ch:
+			if err != ChannelClosed {
+				t.Fatalf("tag %d: expected ChannelClosed, got %s", i+1, err)
+			}
+		default:
+			t.Fatalf("tag %d: expected resultCh to be resolved", i+1)
+		}
+	}
+	if len(p.pending) != 0 {
+		t.Fatalf("expected pending to be drained, got %d entries", len(p.pending))
+	}
+}
+
+func TestFailPendingDropsStaleGeneration(t *testing.T) {
+	p := newTestPublisher()
+	p.generation = 2
+
+	ch := make(chan error, 1)
+	p.pending[1] = ch
+
+	p.failPending(1, ChannelClosed)
+
+	select {
+	case <-ch:
+		t.Fatalf("expected resultCh to be untouched by a stale-generation signal")
+	default:
+	}
+}
@@ -0,0 +1,71 @@
+package relay
+
+import (
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// QueueSpec describes a queue and how it is bound to an exchange. It
+// lets callers opt into topic or fanout routing instead of the simple
+// direct-exchange-bound-by-name default that Consumer/Publisher use.
+type QueueSpec struct {
+	Name         string     // Queue name
+	Exchange     string     // Exchange to declare/bind against. Defaults to the Relay's Config.Exchange
+	ExchangeType string     // "direct", "topic", "fanout", etc. Defaults to "direct"
+	RoutingKey   string     // Routing key used when publishing. Defaults to Name
+	BindingKeys  []string   // Keys to bind Name with. Defaults to []string{RoutingKey}
+	Durable      bool       // Whether the queue survives a broker restart
+	AutoDelete   bool       // Whether the queue is deleted once its last consumer disconnects
+	Args         amqp.Table // Extra arguments passed to QueueDeclare
+}
+
+// normalize fills in the defaults relative to conf, without mutating
+// the original spec.
+func (s QueueSpec) normalize(conf *Config) QueueSpec {
+	if s.Exchange == "" {
+		s.Exchange = conf.Exchange
+	}
+	if s.ExchangeType == "" {
+		s.ExchangeType = "direct"
+	}
+	if s.RoutingKey == "" {
+		s.RoutingKey = s.Name
+	}
+	if len(s.BindingKeys) == 0 {
+		s.BindingKeys = []string{s.RoutingKey}
+	}
+	return s
+}
+
+// declareQueueSpec declares spec's exchange and queue, wires in
+// dead-lettering when Config.DeadLetter is set, and binds the queue to
+// every one of spec.BindingKeys.
+func (r *Relay) declareQueueSpec(ch *amqp.Channel, spec QueueSpec) error {
+	if err := ch.ExchangeDeclare(spec.Exchange, spec.ExchangeType, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("Failed to declare exchange '%s'! Got: %s", spec.Exchange, err)
+	}
+
+	args := amqp.Table{}
+	for k, v := range spec.Args {
+		args[k] = v
+	}
+	if dl := r.conf.DeadLetter; dl != nil {
+		if err := r.declareDeadLetter(ch, spec.Name, dl); err != nil {
+			return err
+		}
+		args["x-dead-letter-exchange"] = deadLetterExchangeName(r.conf, dl)
+		args["x-dead-letter-routing-key"] = spec.Name
+	}
+
+	if _, err := ch.QueueDeclare(spec.Name, spec.Durable, spec.AutoDelete, false, false, args); err != nil {
+		return fmt.Errorf("Failed to declare queue '%s'! Got: %s", spec.Name, err)
+	}
+
+	for _, key := range spec.BindingKeys {
+		if err := ch.QueueBind(spec.Name, key, spec.Exchange, false, nil); err != nil {
+			return fmt.Errorf("Failed to bind queue '%s'! Got: %s", spec.Name, err)
+		}
+	}
+	return nil
+}
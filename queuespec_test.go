@@ -0,0 +1,67 @@
+package relay
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueueSpecNormalizeDefaults(t *testing.T) {
+	conf := &Config{Exchange: "relay"}
+	spec := QueueSpec{Name: "orders"}.normalize(conf)
+
+	if spec.Exchange != "relay" {
+		t.Fatalf("unexpected exchange %q", spec.Exchange)
+	}
+	if spec.ExchangeType != "direct" {
+		t.Fatalf("unexpected exchange type %q", spec.ExchangeType)
+	}
+	if spec.RoutingKey != "orders" {
+		t.Fatalf("unexpected routing key %q", spec.RoutingKey)
+	}
+	if !reflect.DeepEqual(spec.BindingKeys, []string{"orders"}) {
+		t.Fatalf("unexpected binding keys %v", spec.BindingKeys)
+	}
+}
+
+func TestQueueSpecNormalizePreservesExplicitFields(t *testing.T) {
+	conf := &Config{Exchange: "relay"}
+	spec := QueueSpec{
+		Name:         "orders",
+		Exchange:     "events",
+		ExchangeType: "topic",
+		RoutingKey:   "orders.created",
+		BindingKeys:  []string{"orders.*", "orders.created"},
+	}.normalize(conf)
+
+	if spec.Exchange != "events" {
+		t.Fatalf("unexpected exchange %q", spec.Exchange)
+	}
+	if spec.ExchangeType != "topic" {
+		t.Fatalf("unexpected exchange type %q", spec.ExchangeType)
+	}
+	if spec.RoutingKey != "orders.created" {
+		t.Fatalf("unexpected routing key %q", spec.RoutingKey)
+	}
+	if !reflect.DeepEqual(spec.BindingKeys, []string{"orders.*", "orders.created"}) {
+		t.Fatalf("unexpected binding keys %v", spec.BindingKeys)
+	}
+}
+
+func TestQueueSpecNormalizeDoesNotMutateOriginal(t *testing.T) {
+	conf := &Config{Exchange: "relay"}
+	orig := QueueSpec{Name: "orders"}
+	_ = orig.normalize(conf)
+
+	if orig.Exchange != "" || orig.ExchangeType != "" || len(orig.BindingKeys) != 0 {
+		t.Fatalf("normalize mutated the original spec: %#v", orig)
+	}
+}
+
+func TestQueueSpecNormalizeFanoutDefaultsBindingKeyToRoutingKey(t *testing.T) {
+	conf := &Config{Exchange: "relay"}
+	spec := QueueSpec{Name: "notifications", ExchangeType: "fanout", RoutingKey: "ignored-by-fanout"}.normalize(conf)
+
+	if !reflect.DeepEqual(spec.BindingKeys, []string{"ignored-by-fanout"}) {
+		t.Fatalf("unexpected binding keys %v", spec.BindingKeys)
+	}
+}
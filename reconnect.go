@@ -0,0 +1,239 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"github.com/streadway/amqp"
+	"log"
+	"time"
+)
+
+const (
+	defaultReconnectInitialBackoff = 500 * time.Millisecond
+	defaultReconnectMaxBackoff     = 30 * time.Second
+	defaultReconnectPublishTimeout = 30 * time.Second
+)
+
+// reconnect redials the broker with exponential backoff until it succeeds
+// or Config.ReconnectMaxAttempts is exhausted. It is only invoked when
+// Config.AutoReconnect is set.
+func (r *Relay) reconnect(conn **amqp.Connection) {
+	backoff := r.conf.ReconnectInitialBackoff
+	if backoff <= 0 {
+		backoff = defaultReconnectInitialBackoff
+	}
+	maxBackoff := r.conf.ReconnectMaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultReconnectMaxBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		if r.conf.ReconnectMaxAttempts > 0 && attempt > r.conf.ReconnectMaxAttempts {
+			log.Printf("[ERR] Relay giving up reconnecting after %d attempt(s)", attempt-1)
+			r.Lock()
+			*conn = nil
+			r.Unlock()
+			if conn == &r.consConn {
+				r.giveUpConsumers()
+			}
+			return
+		}
+
+		if err := r.redial(conn); err != nil {
+			if err == ChannelClosed {
+				// Relay.Close() won the race with this redial; there's
+				// nothing left to reconnect for.
+				return
+			}
+			log.Printf("[ERR] Relay failed to reconnect (attempt %d): %s", attempt, err)
+			time.Sleep(backoff)
+			backoff = nextBackoff(backoff, maxBackoff)
+			continue
+		}
+
+		log.Printf("[INFO] Relay reconnected after %d attempt(s)", attempt)
+		return
+	}
+}
+
+// giveUpConsumers signals every tracked Consumer that AutoReconnect has
+// permanently given up, waking any call blocked on <-reconnected so it
+// returns ChannelClosed instead of hanging forever. A Consumer that's
+// individually Close'd concurrently already closed its own reconnected
+// (see Consumer.Close), so it's skipped here to avoid closing it twice.
+func (r *Relay) giveUpConsumers() {
+	r.regMu.Lock()
+	defer r.regMu.Unlock()
+	for _, c := range r.consumers {
+		c.mu.Lock()
+		if !c.closed {
+			c.giveUp = true
+			close(c.reconnected)
+			c.reconnected = make(chan struct{})
+		}
+		c.mu.Unlock()
+	}
+}
+
+// nextBackoff doubles backoff, capped at max.
+func nextBackoff(backoff, max time.Duration) time.Duration {
+	if backoff *= 2; backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// redial opens a new connection in place of conn and hands every
+// Consumer/Publisher using that connection a freshly opened channel,
+// re-declaring each one's own QueueSpec (exchange included) along the
+// way.
+func (r *Relay) redial(conn **amqp.Connection) error {
+	// Unlike the initial dial, redials are not bound by the caller's
+	// ctx: a request- or startup-scoped context going out of scope
+	// should not silently and permanently disable AutoReconnect.
+	newConn, err := r.getConn(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// Rebuild every tracked Consumer/Publisher onto newConn before
+	// publishing it to conn or watching it for errors. If a rebuild
+	// fails partway through, close newConn so the channels already
+	// swapped onto it go with it, rather than leaking the connection
+	// and leaving *conn untouched for the next redial attempt to retry.
+	r.regMu.Lock()
+	if conn == &r.consConn {
+		for _, c := range r.consumers {
+			if err := r.rebuildConsumer(newConn, c); err != nil {
+				r.regMu.Unlock()
+				newConn.Close()
+				return err
+			}
+		}
+	} else {
+		for _, p := range r.publishers {
+			if err := r.rebuildPublisher(newConn, p); err != nil {
+				r.regMu.Unlock()
+				newConn.Close()
+				return err
+			}
+		}
+	}
+	r.regMu.Unlock()
+
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		newConn.Close()
+		return ChannelClosed
+	}
+	*conn = newConn
+	r.Unlock()
+
+	errCh := make(chan *amqp.Error)
+	newConn.NotifyClose(errCh)
+	go r.watchConn(conn, errCh)
+
+	return nil
+}
+
+// rebuildConsumer re-declares a Consumer's queue on the new connection and
+// swaps in a freshly opened channel and delivery stream. It is a no-op if
+// c has been Close'd, whether before this call started or while it was
+// opening the channel/declaring the queue/consuming: redial holds regMu
+// for the whole rebuild loop, so a concurrent Consumer.Close() can only
+// flip c.closed (under c.mu) and then block on regMu in
+// deregisterConsumer, never run concurrently with the swap below.
+func (r *Relay) rebuildConsumer(conn *amqp.Connection, c *Consumer) error {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := r.declareQueueSpec(ch, c.spec); err != nil {
+		return err
+	}
+	if r.conf.PrefetchCount > 0 {
+		if err := ch.Qos(r.conf.PrefetchCount, 0, false); err != nil {
+			return fmt.Errorf("Failed to set Qos prefetch! Got: %s", err)
+		}
+	}
+	deliver, err := ch.Consume(c.spec.Name, c.tag, false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to start consuming messages! Got: %s", err)
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		// Close() ran while we were declaring/consuming above. c already
+		// thinks it's shut down and nobody else references ch, so close
+		// it (which also cancels the broker-side consumer tag) instead
+		// of handing a closed Consumer a live channel.
+		ch.Close()
+		return nil
+	}
+	c.channel = ch
+	c.deliver = deliver
+	c.pending = false
+	close(c.reconnected)
+	c.reconnected = make(chan struct{})
+	c.mu.Unlock()
+	return nil
+}
+
+// rebuildPublisher re-declares a Publisher's queue on the new connection
+// and swaps in a freshly opened channel and confirm listeners. It is a
+// no-op if p has been Close'd, whether before this call started or while
+// it was opening the channel/declaring the queue/entering confirm mode;
+// see rebuildConsumer for why the two can't race on the swap itself.
+func (r *Relay) rebuildPublisher(conn *amqp.Connection, p *Publisher) error {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return nil
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := r.declareQueueSpec(ch, p.spec); err != nil {
+		return err
+	}
+
+	var ackCh, nackCh chan uint64
+	var errCh chan *amqp.Error
+	if !r.conf.DisablePublishConfirm {
+		errCh = ch.NotifyClose(make(chan *amqp.Error, 1))
+		ackCh, nackCh = ch.NotifyConfirm(make(chan uint64, 1), make(chan uint64, 1))
+		if err := ch.Confirm(false); err != nil {
+			return fmt.Errorf("Failed to put publisher in confirm mode! Got: %s", err)
+		}
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		// Close() ran while we were declaring/entering confirm mode
+		// above. p already thinks it's shut down and nobody else
+		// references ch, so close it instead of handing a closed
+		// Publisher a live channel.
+		ch.Close()
+		return nil
+	}
+	p.channel = ch
+	p.ackCh, p.nackCh, p.errCh = ackCh, nackCh, errCh
+	close(p.reconnected)
+	p.reconnected = make(chan struct{})
+	p.mu.Unlock()
+	p.startConfirmLoop()
+	return nil
+}
@@ -0,0 +1,20 @@
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextBackoffDoubles(t *testing.T) {
+	got := nextBackoff(500*time.Millisecond, 30*time.Second)
+	if got != time.Second {
+		t.Fatalf("expected 1s, got %s", got)
+	}
+}
+
+func TestNextBackoffCapsAtMax(t *testing.T) {
+	got := nextBackoff(20*time.Second, 30*time.Second)
+	if got != 30*time.Second {
+		t.Fatalf("expected capped at 30s, got %s", got)
+	}
+}
@@ -1,11 +1,15 @@
 package relay
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/streadway/amqp"
 	"log"
+	"net"
 	"runtime"
 	"sync"
+	"time"
 )
 
 // Config is passed into New when creating a Relay to tune
@@ -23,13 +27,67 @@ type Config struct {
 	DisablePersistence    bool       // Disables message persistence
 	Exchange              string     // Custom exchange. Defaults to "relay"
 	Serializer            Serializer // Defaults to GOBSerializer
+
+	// Auth lists the SASL mechanisms to offer the broker, in order of
+	// preference (e.g. &amqp.PlainAuth{}, &amqp.AMQPlainAuth{}, or
+	// &amqp.ExternalAuth{} for x509 client-cert auth). Defaults to PLAIN
+	// using Username/Password when unset.
+	Auth []amqp.Authentication
+
+	// TLSConfig is used to dial when EnableTLS is set, and must be
+	// supplied to authenticate with EXTERNAL (client certificate) auth.
+	TLSConfig *tls.Config
+
+	// AutoReconnect enables transparent redialing of the broker when the
+	// underlying connection is lost. When set, Relay re-declares the
+	// exchange and every tracked queue on reconnect, and hands each live
+	// Consumer/Publisher a freshly opened channel.
+	AutoReconnect bool
+
+	// ReconnectInitialBackoff is the delay after the first failed
+	// reconnect attempt; the first attempt itself is made immediately.
+	// Defaults to 500ms. Only used when AutoReconnect is set.
+	ReconnectInitialBackoff time.Duration
+
+	// ReconnectMaxBackoff caps the exponential backoff between reconnect
+	// attempts. Defaults to 30s. Only used when AutoReconnect is set.
+	ReconnectMaxBackoff time.Duration
+
+	// ReconnectMaxAttempts bounds how many times Relay will try to
+	// reconnect before giving up. 0 (the default) means unlimited.
+	ReconnectMaxAttempts int
+
+	// ReconnectPublishTimeout bounds how long Publish blocks waiting for
+	// a reconnect to finish before giving up. Defaults to 30s. Only used
+	// when AutoReconnect is set.
+	ReconnectPublishTimeout time.Duration
+
+	// DeadLetter, when set, wires every queue declared by this Relay to
+	// a dead-letter exchange/queue, and bounds how many times a Consumer
+	// will requeue a message via Nack before routing it there instead.
+	DeadLetter *DeadLetterConfig
+}
+
+// DeadLetterConfig configures dead-lettering for queues declared by a
+// Relay, so poison messages stop looping through Nack forever.
+type DeadLetterConfig struct {
+	Exchange      string // Dead-letter exchange name. Defaults to "<relay-exchange>.dlx"
+	QueueSuffix   string // Suffix appended to the queue name to form the DLQ name. Defaults to ".dlq"
+	MaxDeliveries int    // Requeue attempts allowed via Nack before dead-lettering. 0 means unlimited
 }
 
 type Relay struct {
 	sync.Mutex
 	conf     *Config
+	ctx      context.Context  // Bounds every dial getChan makes; AutoReconnect's redials use context.Background()
 	pubConn  *amqp.Connection // Publisher connection.
 	consConn *amqp.Connection // Consumer connection. Avoid TCP backpressure.
+
+	closed bool // set by Close, checked by redial before handing off a new connection
+
+	regMu      sync.Mutex
+	consumers  []*Consumer // Tracked for re-declaration after a reconnect
+	publishers []*Publisher
 }
 
 // Returned to indicate a closed channel
@@ -40,6 +98,22 @@ var ChannelClosed = fmt.Errorf("Channel closed!")
 // the configuration once New is invoked, nor should it be
 // shared between multiple relays.
 func New(c *Config) (*Relay, error) {
+	return NewContext(context.Background(), c)
+}
+
+// NewContext behaves like New, but ctx bounds the dials made when a
+// Consumer or Publisher needs a connection and none is open; New itself
+// does not dial. Canceling ctx aborts an in-flight dial with an error
+// that matches ctx.Err() under errors.Is. It is
+// deliberately not reused for Config.AutoReconnect's later redials, since
+// a request- or startup-scoped ctx going out of scope would otherwise
+// silently and permanently stop reconnection; redials use
+// context.Background() instead.
+func NewContext(ctx context.Context, c *Config) (*Relay, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	// Set the defaults if missing
 	if c.Addr == "" {
 		c.Addr = "localhost"
@@ -68,14 +142,30 @@ func New(c *Config) (*Relay, error) {
 	}
 
 	// Create relay with finalizer
-	r := &Relay{conf: c}
+	r := &Relay{conf: c, ctx: ctx}
 	runtime.SetFinalizer(r, (*Relay).Close)
 	return r, nil
 }
 
-// Used to get a new server connection
-func (r *Relay) getConn() (*amqp.Connection, error) {
+// Used to get a new server connection. ctx bounds the dial: getChan uses
+// r.ctx (the context NewContext was given) whenever it opens a connection,
+// while redial uses context.Background() so losing the caller's ctx
+// doesn't stop AutoReconnect from redialing.
+func (r *Relay) getConn(ctx context.Context) (*amqp.Connection, error) {
 	conf := r.conf
+	dialConf := amqp.Config{
+		SASL:            saslMechanisms(conf),
+		TLSClientConfig: conf.TLSConfig,
+		Dial: func(network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+	return amqp.DialConfig(dialURI(conf).String(), dialConf)
+}
+
+// dialURI builds the amqp.URI to dial, using the "amqps" scheme when
+// Config.EnableTLS is set.
+func dialURI(conf *Config) amqp.URI {
 	uri := amqp.URI{Host: conf.Addr, Port: conf.Port,
 		Username: conf.Username, Password: conf.Password,
 		Vhost: conf.Vhost}
@@ -84,8 +174,17 @@ func (r *Relay) getConn() (*amqp.Connection, error) {
 	} else {
 		uri.Scheme = "amqp"
 	}
-	uri_s := uri.String()
-	return amqp.Dial(uri_s)
+	return uri
+}
+
+// saslMechanisms returns the SASL mechanisms to offer the broker,
+// falling back to PLAIN using Username/Password when Config.Auth
+// doesn't explicitly configure any.
+func saslMechanisms(conf *Config) []amqp.Authentication {
+	if len(conf.Auth) > 0 {
+		return conf.Auth
+	}
+	return []amqp.Authentication{&amqp.PlainAuth{Username: conf.Username, Password: conf.Password}}
 }
 
 // Watches for connection errors and closes the connection
@@ -107,6 +206,13 @@ func (r *Relay) watchConn(conn **amqp.Connection, errCh chan *amqp.Error) {
 		}
 	}
 
+	// If auto-reconnect is enabled, redial instead of abandoning the
+	// connection for good.
+	if r.conf.AutoReconnect {
+		r.reconnect(conn)
+		return
+	}
+
 	// Unset the connection
 	r.Lock()
 	defer r.Unlock()
@@ -120,14 +226,12 @@ func (r *Relay) getChan(conn **amqp.Connection) (*amqp.Channel, error) {
 	defer r.Unlock()
 
 	// Get a connection if none
-	var isNew bool
 	if *conn == nil {
-		newConn, err := r.getConn()
+		newConn, err := r.getConn(r.ctx)
 		if err != nil {
 			return nil, err
 		}
 		*conn = newConn
-		isNew = true
 
 		// Watch for connection errors
 		errCh := make(chan *amqp.Error)
@@ -135,35 +239,10 @@ func (r *Relay) getChan(conn **amqp.Connection) (*amqp.Channel, error) {
 		go r.watchConn(conn, errCh)
 	}
 
-	// Get a channel
-	ch, err := (*conn).Channel()
-	if err != nil {
-		return nil, err
-	}
-
-	// Declare an exchange if this is a new connection
-	if isNew {
-		if err := ch.ExchangeDeclare(r.conf.Exchange, "direct", true, false, false, false, nil); err != nil {
-			return nil, fmt.Errorf("Failed to declare exchange '%s'! Got: %s", r.conf.Exchange, err)
-		}
-	}
-
-	// Return the channel
-	return ch, nil
-}
-
-// Ensures the given queue exists and is bound to the exchange
-func (r *Relay) declareQueue(ch *amqp.Channel, name string) error {
-	// Declare the queue
-	if _, err := ch.QueueDeclare(name, true, false, false, false, nil); err != nil {
-		return fmt.Errorf("Failed to declare queue '%s'! Got: %s", name, err)
-	}
-
-	// Bind the queue to the exchange
-	if err := ch.QueueBind(name, name, r.conf.Exchange, false, nil); err != nil {
-		return fmt.Errorf("Failed to bind queue '%s'! Got: %s", name, err)
-	}
-	return nil
+	// Get a channel. Exchange declaration happens per QueueSpec in
+	// declareQueueSpec, since different Consumers/Publishers on the same
+	// connection may use different exchanges.
+	return (*conn).Channel()
 }
 
 // Close will shutdown the relay. It is best to first Close all the
@@ -173,6 +252,8 @@ func (r *Relay) Close() error {
 	r.Lock()
 	defer r.Unlock()
 
+	r.closed = true
+
 	var errors []error
 	if r.pubConn != nil {
 		if err := r.pubConn.Close(); err != nil {
@@ -186,6 +267,14 @@ func (r *Relay) Close() error {
 		}
 		r.consConn = nil
 	}
+
+	// Closing consConn above kills every still-registered Consumer's
+	// delivery channel, but (unlike cons.Close()) doesn't tell it to
+	// give up: with AutoReconnect set, a Consumer left open by the
+	// caller would otherwise wait forever on a reconnect that's never
+	// coming.
+	r.giveUpConsumers()
+
 	switch len(errors) {
 	case 1:
 		return errors[0]
@@ -196,9 +285,21 @@ func (r *Relay) Close() error {
 	}
 }
 
-// Consumer will return a new handle that can be used
-// to consume messages from a given queue.
+// Consumer will return a new handle that can be used to consume
+// messages from a given queue. It is a thin shim over ConsumerSpec that
+// builds a durable queue bound to the Relay's default direct exchange
+// under its own name.
 func (r *Relay) Consumer(queue string) (*Consumer, error) {
+	return r.ConsumerSpec(QueueSpec{Name: queueName(queue), Durable: true})
+}
+
+// ConsumerSpec returns a new handle that can be used to consume
+// messages from the queue described by spec, which may be bound to a
+// topic or fanout exchange with arbitrary binding keys instead of the
+// default direct-exchange-by-name routing Consumer uses.
+func (r *Relay) ConsumerSpec(spec QueueSpec) (*Consumer, error) {
+	spec = spec.normalize(r.conf)
+
 	// Get a new channel
 	ch, err := r.getChan(&r.consConn)
 	if err != nil {
@@ -206,8 +307,7 @@ func (r *Relay) Consumer(queue string) (*Consumer, error) {
 	}
 
 	// Ensure the queue exists
-	name := queueName(queue)
-	if err := r.declareQueue(ch, name); err != nil {
+	if err := r.declareQueueSpec(ch, spec); err != nil {
 		return nil, err
 	}
 
@@ -225,22 +325,47 @@ func (r *Relay) Consumer(queue string) (*Consumer, error) {
 	}
 
 	// Start the consumer
-	readCh, err := ch.Consume(name, consName, false, false, false, false, nil)
+	readCh, err := ch.Consume(spec.Name, consName, false, false, false, false, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to start consuming messages! Got: %s", err)
 	}
 
 	// Create a new Consumer
-	cons := &Consumer{r.conf, consName, name, ch, readCh, 0, false}
+	cons := &Consumer{
+		conf:        r.conf,
+		relay:       r,
+		tag:         consName,
+		spec:        spec,
+		channel:     ch,
+		deliver:     readCh,
+		reconnected: make(chan struct{}),
+	}
+
+	// Track so we can re-declare and hand off a new channel on reconnect
+	r.regMu.Lock()
+	r.consumers = append(r.consumers, cons)
+	r.regMu.Unlock()
 
 	// Set finalizer to ensure we close the channel
 	runtime.SetFinalizer(cons, (*Consumer).Close)
 	return cons, nil
 }
 
-// Publisher will return a new handle that can be used
-// to publish messages to the given queue.
+// Publisher will return a new handle that can be used to publish
+// messages to the given queue. It is a thin shim over PublisherSpec
+// that builds a durable queue bound to the Relay's default direct
+// exchange under its own name.
 func (r *Relay) Publisher(queue string) (*Publisher, error) {
+	return r.PublisherSpec(QueueSpec{Name: queueName(queue), Durable: true})
+}
+
+// PublisherSpec returns a new handle that can be used to publish
+// messages to the queue described by spec, which may be bound to a
+// topic or fanout exchange instead of the default direct-exchange-by-
+// name routing Publisher uses.
+func (r *Relay) PublisherSpec(spec QueueSpec) (*Publisher, error) {
+	spec = spec.normalize(r.conf)
+
 	// Get a new channel
 	ch, err := r.getChan(&r.pubConn)
 	if err != nil {
@@ -248,8 +373,7 @@ func (r *Relay) Publisher(queue string) (*Publisher, error) {
 	}
 
 	// Ensure the queue exists
-	name := queueName(queue)
-	if err := r.declareQueue(ch, name); err != nil {
+	if err := r.declareQueueSpec(ch, spec); err != nil {
 		return nil, err
 	}
 
@@ -265,8 +389,8 @@ func (r *Relay) Publisher(queue string) (*Publisher, error) {
 	}
 
 	// Create a new Publisher
-	pub := &Publisher{conf: r.conf, queue: name, channel: ch,
-		contentType: contentType, mode: mode}
+	pub := &Publisher{conf: r.conf, relay: r, spec: spec, channel: ch,
+		contentType: contentType, mode: mode, reconnected: make(chan struct{})}
 
 	// Check if we need confirmations
 	if !r.conf.DisablePublishConfirm {
@@ -279,8 +403,38 @@ func (r *Relay) Publisher(queue string) (*Publisher, error) {
 		// Attach the channels
 		pub.ackCh, pub.nackCh, pub.errCh = ackCh, nackCh, errCh
 	}
+	pub.startConfirmLoop()
+
+	// Track so we can re-declare and hand off a new channel on reconnect
+	r.regMu.Lock()
+	r.publishers = append(r.publishers, pub)
+	r.regMu.Unlock()
 
 	// Set finalizer to ensure we close the channel
 	runtime.SetFinalizer(pub, (*Publisher).Close)
 	return pub, nil
 }
+
+// deregisterConsumer stops tracking a Consumer for reconnect handling.
+func (r *Relay) deregisterConsumer(c *Consumer) {
+	r.regMu.Lock()
+	defer r.regMu.Unlock()
+	for i, v := range r.consumers {
+		if v == c {
+			r.consumers = append(r.consumers[:i], r.consumers[i+1:]...)
+			return
+		}
+	}
+}
+
+// deregisterPublisher stops tracking a Publisher for reconnect handling.
+func (r *Relay) deregisterPublisher(p *Publisher) {
+	r.regMu.Lock()
+	defer r.regMu.Unlock()
+	for i, v := range r.publishers {
+		if v == p {
+			r.publishers = append(r.publishers[:i], r.publishers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestGetConnHonorsCancelledContext proves ctx actually bounds the dial:
+// with no broker running, getConn would otherwise hang retrying TCP
+// connect to a closed port, but a pre-cancelled ctx must abort the dial
+// immediately with ctx.Err() instead.
+func TestGetConnHonorsCancelledContext(t *testing.T) {
+	conf := &Config{Addr: "127.0.0.1", Port: 1}
+	r, err := New(conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := r.getConn(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package relay
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestDialURIScheme(t *testing.T) {
+	conf := &Config{Addr: "broker.local", Port: 5672}
+	if uri := dialURI(conf); uri.Scheme != "amqp" {
+		t.Fatalf("expected amqp scheme, got %q", uri.Scheme)
+	}
+
+	conf = &Config{Addr: "broker.local", Port: 5671, EnableTLS: true}
+	if uri := dialURI(conf); uri.Scheme != "amqps" {
+		t.Fatalf("expected amqps scheme, got %q", uri.Scheme)
+	}
+}
+
+func TestSASLMechanismsDefaultsToPlainAuth(t *testing.T) {
+	conf := &Config{Username: "user", Password: "pass"}
+	sasl := saslMechanisms(conf)
+	if len(sasl) != 1 {
+		t.Fatalf("expected 1 mechanism, got %d", len(sasl))
+	}
+	plain, ok := sasl[0].(*amqp.PlainAuth)
+	if !ok {
+		t.Fatalf("expected *amqp.PlainAuth, got %T", sasl[0])
+	}
+	if plain.Username != "user" || plain.Password != "pass" {
+		t.Fatalf("unexpected PlainAuth credentials: %+v", plain)
+	}
+}
+
+func TestSASLMechanismsHonorsExplicitAuth(t *testing.T) {
+	custom := []amqp.Authentication{&amqp.AMQPlainAuth{Username: "user", Password: "pass"}}
+	conf := &Config{Username: "user", Password: "pass", Auth: custom}
+
+	sasl := saslMechanisms(conf)
+	if len(sasl) != 1 || sasl[0] != custom[0] {
+		t.Fatalf("expected configured Auth to be used as-is, got %+v", sasl)
+	}
+}
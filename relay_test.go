@@ -1,10 +1,14 @@
 package relay
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/streadway/amqp"
 )
 
 func CheckInteg(t *testing.T) {
@@ -97,6 +101,80 @@ func TestPublishNoPersist(t *testing.T) {
 	testSendRecv(t, r)
 }
 
+func TestExplicitPlainAuth(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{
+		Addr: AMQPHost(),
+		Auth: []amqp.Authentication{&amqp.PlainAuth{Username: "guest", Password: "guest"}},
+	}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	testSendRecv(t, r)
+}
+
+func TestAutoReconnectSendRecv(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost(), AutoReconnect: true}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	testSendRecv(t, r)
+}
+
+// TestAutoReconnectTopicExchange forces an actual reconnect of a
+// Publisher bound to the Relay's default exchange under topic routing
+// (rather than the default direct-by-name Publisher/Consumer), to catch
+// redial re-declaring that same exchange name as "direct" and
+// permanently breaking reconnection with PRECONDITION_FAILED.
+func TestAutoReconnectTopicExchange(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost(), Exchange: "auto-reconnect-topic", AutoReconnect: true, ReconnectPublishTimeout: 2 * time.Second}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	pub, err := r.PublisherSpec(QueueSpec{Name: "auto-reconnect-topic", ExchangeType: "topic", RoutingKey: "foo.bar"})
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer pub.Close()
+
+	cons, err := r.ConsumerSpec(QueueSpec{Name: "auto-reconnect-topic", ExchangeType: "topic", BindingKeys: []string{"foo.*"}})
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer cons.Close()
+
+	// Force the publisher's connection closed so AutoReconnect has to
+	// redial and re-declare the topic exchange.
+	r.pubConn.Close()
+
+	msg := "reconnected"
+	if err := pub.Publish(msg); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	var in string
+	if err := cons.ConsumeAck(&in); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if in != msg {
+		t.Fatalf("unexpected msg! %v %v", in, msg)
+	}
+}
+
 func TestCustomExchange(t *testing.T) {
 	CheckInteg(t)
 
@@ -196,7 +274,7 @@ func TestMultiConsume(t *testing.T) {
 
 	// Send a message
 	for i := 0; i < 5; i++ {
-		err = pub.Publish(string(i))
+		err = pub.Publish(fmt.Sprint(i))
 		if err != nil {
 			t.Fatalf("unexpected err %s", err)
 		}
@@ -209,7 +287,7 @@ func TestMultiConsume(t *testing.T) {
 		if err != nil {
 			t.Fatalf("unexpected err %s", err)
 		}
-		if in != string(i) {
+		if in != fmt.Sprint(i) {
 			t.Fatalf("unexpected msg! %v %v", in, i)
 		}
 	}
@@ -226,12 +304,349 @@ func TestMultiConsume(t *testing.T) {
 		if err != nil {
 			t.Fatalf("unexpected err %s", err)
 		}
-		if in != string(i) {
+		if in != fmt.Sprint(i) {
 			t.Fatalf("unexpected msg! %v %v", in, i)
 		}
 	}
 }
 
+func TestQueueSpecTopicRouting(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost()}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	pub, err := r.PublisherSpec(QueueSpec{
+		Name:         "topic-routing",
+		Exchange:     "topic-exchange",
+		ExchangeType: "topic",
+		RoutingKey:   "orders.created",
+	})
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer pub.Close()
+
+	cons, err := r.ConsumerSpec(QueueSpec{
+		Name:         "topic-routing",
+		Exchange:     "topic-exchange",
+		ExchangeType: "topic",
+		BindingKeys:  []string{"orders.*"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer cons.Close()
+
+	msg := "order created"
+	if err := pub.Publish(msg); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	var in string
+	if err := cons.ConsumeAck(&in); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if in != msg {
+		t.Fatalf("unexpected msg! %v %v", in, msg)
+	}
+}
+
+func TestQueueSpecFanoutRouting(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost()}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	pub, err := r.PublisherSpec(QueueSpec{
+		Name:         "fanout-routing-pub",
+		Exchange:     "fanout-exchange",
+		ExchangeType: "fanout",
+	})
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer pub.Close()
+
+	// Two independent queues both bound to the fanout exchange should
+	// each get a copy of the message, regardless of routing key.
+	consA, err := r.ConsumerSpec(QueueSpec{Name: "fanout-routing-a", Exchange: "fanout-exchange", ExchangeType: "fanout"})
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer consA.Close()
+
+	consB, err := r.ConsumerSpec(QueueSpec{Name: "fanout-routing-b", Exchange: "fanout-exchange", ExchangeType: "fanout"})
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer consB.Close()
+
+	msg := "broadcast"
+	if err := pub.Publish(msg); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	for _, cons := range []*Consumer{consA, consB} {
+		var in string
+		if err := cons.ConsumeAck(&in); err != nil {
+			t.Fatalf("unexpected err %s", err)
+		}
+		if in != msg {
+			t.Fatalf("unexpected msg! %v %v", in, msg)
+		}
+	}
+}
+
+func TestPublishAsyncResolvesOnConfirm(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost()}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	pub, err := r.Publisher("publish-async")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer pub.Close()
+
+	cons, err := r.Consumer("publish-async")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer cons.Close()
+
+	resultCh, err := pub.PublishAsync("test")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	var in string
+	if err := cons.ConsumeAck(&in); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	if err := <-resultCh; err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+}
+
+func TestPublishBatch(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost()}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	pub, err := r.Publisher("publish-batch")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer pub.Close()
+
+	cons, err := r.Consumer("publish-batch")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer cons.Close()
+
+	msgs := []interface{}{"one", "two", "three"}
+	done := make(chan error, 1)
+	go func() {
+		done <- pub.PublishBatch(msgs)
+	}()
+
+	var in string
+	for i := range msgs {
+		if err := cons.ConsumeAck(&in); err != nil {
+			t.Fatalf("unexpected err %s", err)
+		}
+		if in != msgs[i] {
+			t.Fatalf("unexpected msg! %v %v", in, msgs[i])
+		}
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+}
+
+func TestDeadLetterAfterMaxDeliveries(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost(), DeadLetter: &DeadLetterConfig{MaxDeliveries: 2}}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	pub, err := r.Publisher("dead-letter")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer pub.Close()
+
+	cons, err := r.Consumer("dead-letter")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer cons.Close()
+
+	msg := "poison message"
+	if err := pub.Publish(msg); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	// Nack it twice, exceeding MaxDeliveries, so the third Nack should
+	// dead-letter it instead of requeueing it.
+	var in string
+	for i := 0; i < 2; i++ {
+		if err := cons.Consume(&in); err != nil {
+			t.Fatalf("unexpected err %s", err)
+		}
+		if err := cons.Nack(); err != nil {
+			t.Fatalf("unexpected err %s", err)
+		}
+	}
+	if err := cons.Consume(&in); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if got := cons.DeliveryCount(); got != 2 {
+		t.Fatalf("expected delivery count 2, got %d", got)
+	}
+	if err := cons.Nack(); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	// The message should now be sitting in the DLQ rather than
+	// redelivered on "dead-letter".
+	dlCons, err := r.Consumer("dead-letter.dlq")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer dlCons.Close()
+
+	if err := dlCons.ConsumeTimeout(&in, time.Second); err != nil {
+		t.Fatalf("expected dead-lettered message, got err %s", err)
+	}
+	// The x-death trail built up on the original queue should have
+	// survived the republish into the DLQ.
+	if got := dlCons.DeliveryCount(); got != 2 {
+		t.Fatalf("expected dead-lettered message to carry its x-death history, got delivery count %d", got)
+	}
+	if err := dlCons.Ack(); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if in != msg {
+		t.Fatalf("unexpected msg! %v %v", in, msg)
+	}
+}
+
+func TestDeadLetterWithMultiAckPreservesRestOfBatch(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{
+		Addr:           AMQPHost(),
+		PrefetchCount:  5,
+		EnableMultiAck: true,
+		DeadLetter:     &DeadLetterConfig{MaxDeliveries: 1},
+	}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	pub, err := r.Publisher("dead-letter-multi")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer pub.Close()
+
+	cons, err := r.Consumer("dead-letter-multi")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer cons.Close()
+
+	if err := pub.Publish("healthy"); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if err := pub.Publish("poison"); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	var in string
+	// First pass: neither message has exceeded MaxDeliveries yet, so the
+	// multi-Nack requeues both.
+	if err := cons.Consume(&in); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if err := cons.Consume(&in); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if err := cons.Nack(); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	// Second pass: "poison" (now at its 2nd delivery) exceeds
+	// MaxDeliveries and is dead-lettered, but "healthy" (still part of
+	// the same pending batch) must be requeued, not silently dropped.
+	if err := cons.Consume(&in); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if err := cons.Consume(&in); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if in != "poison" {
+		t.Fatalf("expected poison to be last delivered, got %q", in)
+	}
+	if err := cons.Nack(); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	// "healthy" should come back around on the main queue...
+	if err := cons.ConsumeAck(&in); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if in != "healthy" {
+		t.Fatalf("expected healthy to be requeued rather than lost, got %q", in)
+	}
+
+	// ...while "poison" should be sitting in the DLQ.
+	dlCons, err := r.Consumer("dead-letter-multi.dlq")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer dlCons.Close()
+
+	if err := dlCons.ConsumeTimeout(&in, time.Second); err != nil {
+		t.Fatalf("expected dead-lettered message, got err %s", err)
+	}
+	if in != "poison" {
+		t.Fatalf("unexpected dead-lettered msg! %v", in)
+	}
+	if err := dlCons.Ack(); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+}
+
 func TestCloseRelayInUse(t *testing.T) {
 	CheckInteg(t)
 
@@ -263,12 +678,13 @@ func TestCloseRelayInUse(t *testing.T) {
 	go func() {
 		defer wg.Done()
 		for i := 0; i < 100; i++ {
-			err := pub.Publish(string(i))
+			err := pub.Publish(fmt.Sprint(i))
 			if err == ChannelClosed {
 				break
 			}
 			if err != nil {
-				t.Fatalf("unexpected err %s", err)
+				t.Errorf("unexpected err %s", err)
+				return
 			}
 		}
 	}()
@@ -283,10 +699,12 @@ func TestCloseRelayInUse(t *testing.T) {
 				break
 			}
 			if err != nil {
-				t.Fatalf("unexpected err %s", err)
+				t.Errorf("unexpected err %s", err)
+				return
 			}
-			if in != string(i) {
-				t.Fatalf("unexpected msg! %v %v", in, i)
+			if in != fmt.Sprint(i) {
+				t.Errorf("unexpected msg! %v %v", in, i)
+				return
 			}
 		}
 	}()
@@ -296,7 +714,7 @@ func TestCloseRelayInUse(t *testing.T) {
 		time.Sleep(time.Millisecond)
 		err := r.Close()
 		if err != nil {
-			t.Fatalf("unexpected err %s", err)
+			t.Errorf("unexpected err %s", err)
 		}
 	}()
 
@@ -328,7 +746,7 @@ func TestClosePendingMsg(t *testing.T) {
 
 	// Send a message
 	for i := 0; i < 20; i++ {
-		err = pub.Publish(string(i))
+		err = pub.Publish(fmt.Sprint(i))
 		if err != nil {
 			t.Fatalf("unexpected err %s", err)
 		}
@@ -356,7 +774,7 @@ func TestClosePendingMsg(t *testing.T) {
 		if err != nil {
 			t.Fatalf("unexpected err %s", err)
 		}
-		if in != string(i) {
+		if in != fmt.Sprint(i) {
 			t.Fatalf("unexpected msg! %v %v", in, i)
 		}
 	}
@@ -444,6 +862,288 @@ func TestClosePublish(t *testing.T) {
 	}
 }
 
+func TestConsumeCtxTimesOutWhenNoMessage(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost()}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	cons, err := r.Consumer("ctx-no-msg")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer cons.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var in string
+	err = cons.ConsumeCtx(ctx, &in)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected err %s", err)
+	}
+}
+
+func TestPublishCtxConsumeAckCtxSendRecv(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost()}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	pub, err := r.Publisher("ctx-send-recv")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer pub.Close()
+
+	cons, err := r.Consumer("ctx-send-recv")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer cons.Close()
+
+	ctx := context.Background()
+	msg := "the quick brown fox jumps over the lazy dog"
+	if err := pub.PublishCtx(ctx, msg); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	var in string
+	if err := cons.ConsumeAckCtx(ctx, &in); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if in != msg {
+		t.Fatalf("unexpected msg! %v %v", in, msg)
+	}
+}
+
+// TestPublishCtxPreCancelledDoesNotPublish proves a pre-cancelled ctx
+// aborts PublishCtx before the message is written to the channel, even
+// with confirms disabled: a caller that retries after seeing ctx.Err()
+// must not find the message already delivered.
+func TestPublishCtxPreCancelledDoesNotPublish(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost(), DisablePublishConfirm: true}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	pub, err := r.Publisher("ctx-precancelled")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer pub.Close()
+
+	cons, err := r.Consumer("ctx-precancelled")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer cons.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := pub.PublishCtx(ctx, "should not be sent"); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	var in string
+	if err := cons.ConsumeTimeout(&in, 200*time.Millisecond); err == nil {
+		t.Fatalf("expected no message to have been published, got %q", in)
+	}
+}
+
+// TestCloseConsumerDuringReconnect races Consumer.Close() against a
+// forced redial: if rebuildConsumer doesn't notice the Consumer was
+// closed out from under it, it hands the already-"closed" Consumer a
+// live channel and broker-side consumer tag that nothing ever closes.
+func TestCloseConsumerDuringReconnect(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost(), AutoReconnect: true}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	for i := 0; i < 20; i++ {
+		cons, err := r.Consumer(fmt.Sprintf("close-during-reconnect-%d", i))
+		if err != nil {
+			t.Fatalf("unexpected err %s", err)
+		}
+
+		go r.consConn.Close()
+		if err := cons.Close(); err != nil {
+			t.Fatalf("unexpected err %s", err)
+		}
+
+		if _, err := cons.ConsumeId(new(string)); err != ChannelClosed {
+			t.Fatalf("expected ChannelClosed on a closed Consumer, got %v", err)
+		}
+	}
+}
+
+// TestClosePublisherDuringReconnect is the Publisher-side counterpart of
+// TestCloseConsumerDuringReconnect.
+func TestClosePublisherDuringReconnect(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost(), AutoReconnect: true}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	for i := 0; i < 20; i++ {
+		pub, err := r.Publisher(fmt.Sprintf("close-during-reconnect-%d", i))
+		if err != nil {
+			t.Fatalf("unexpected err %s", err)
+		}
+
+		go r.pubConn.Close()
+		if err := pub.Close(); err != nil {
+			t.Fatalf("unexpected err %s", err)
+		}
+
+		if err := pub.Publish("should not be sent"); err != ChannelClosed {
+			t.Fatalf("expected ChannelClosed on a closed Publisher, got %v", err)
+		}
+	}
+}
+
+// TestCloseRelayDuringReconnect races Relay.Close() against a forced
+// redial: if redial doesn't notice the Relay was closed out from under
+// it, it overwrites consConn/pubConn with a brand-new connection right
+// after Close() returns, undoing the Close.
+func TestCloseRelayDuringReconnect(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost(), AutoReconnect: true}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	if _, err := r.Consumer("close-relay-during-reconnect"); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	go r.consConn.Close()
+	if err := r.Close(); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	// Give a racing redial a chance to run before we check; if Close()
+	// lost the race, consConn will have been reassigned to a live
+	// connection instead of staying nil.
+	time.Sleep(100 * time.Millisecond)
+
+	r.Lock()
+	consConn := r.consConn
+	r.Unlock()
+	if consConn != nil {
+		t.Fatalf("expected Close to stick, but redial reassigned consConn")
+	}
+}
+
+// TestCloseUnblocksBlockedConsume races Consumer.Close() against a
+// plain, blocking Consume() call with no timeout or ctx (the primary
+// documented API): with AutoReconnect set, the delivery channel dying
+// under Close() sends Consume() into the path that waits on
+// <-c.reconnected for a rebuild that's never coming, since this
+// Consumer was deliberately closed rather than mid-reconnect. Close()
+// must wake that wait instead of leaving it hanging forever.
+func TestCloseUnblocksBlockedConsume(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost(), AutoReconnect: true}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	defer r.Close()
+
+	cons, err := r.Consumer("close-unblocks-consume")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var in string
+		done <- cons.Consume(&in)
+	}()
+
+	// Give Consume a moment to actually block on the empty queue before
+	// racing Close() against it.
+	time.Sleep(50 * time.Millisecond)
+	if err := cons.Close(); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != ChannelClosed {
+			t.Fatalf("expected ChannelClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Consume blocked forever instead of unblocking on Close")
+	}
+}
+
+// TestCloseRelayUnblocksBlockedConsume is the Relay-level counterpart of
+// TestCloseUnblocksBlockedConsume: Relay.Close() never individually
+// Close's the Consumers it still tracks, so it must wake them itself
+// rather than leaving them waiting on a reconnect signal that's never
+// coming once the Relay is shutting down.
+func TestCloseRelayUnblocksBlockedConsume(t *testing.T) {
+	CheckInteg(t)
+
+	conf := Config{Addr: AMQPHost(), AutoReconnect: true}
+	r, err := New(&conf)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	cons, err := r.Consumer("close-relay-unblocks-consume")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var in string
+		done <- cons.Consume(&in)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := r.Close(); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != ChannelClosed {
+			t.Fatalf("expected ChannelClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Consume blocked forever instead of unblocking on Relay.Close")
+	}
+}
+
 func TestNoHost(t *testing.T) {
 	// Hopefully no rabbit there....
 	conf := Config{Addr: "127.0.0.1", Port: 1}
@@ -0,0 +1,105 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// Serializer is used to encode and decode values as they pass
+// through a Relay. Implementations also advertise a ContentType
+// which is attached to published messages so consumers can tell
+// how a message was encoded.
+type Serializer interface {
+	// ContentType returns the MIME type used for published messages
+	ContentType() string
+
+	// Encode serializes the given value into bytes
+	Encode(in interface{}) ([]byte, error)
+
+	// Decode deserializes bytes into the given value
+	Decode(in []byte, out interface{}) error
+}
+
+// GOBSerializer uses the standard library's encoding/gob package.
+// This is the default Serializer used by Relay.
+type GOBSerializer struct{}
+
+func (g *GOBSerializer) ContentType() string {
+	return "application/x-gob"
+}
+
+func (g *GOBSerializer) Encode(in interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(in); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g *GOBSerializer) Decode(in []byte, out interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(in)).Decode(out)
+}
+
+// SerializerRegistry dispatches to a Serializer based on an inbound
+// message's ContentType, so a single Consumer can decode messages
+// published by producers using different serializations (e.g. a
+// non-Go producer publishing JSON alongside Go services using GOB).
+// Outbound messages are always encoded with Default.
+type SerializerRegistry struct {
+	Default Serializer // Used to Encode, and as a fallback for unrecognized ContentTypes
+
+	mu     sync.RWMutex
+	byType map[string]Serializer
+}
+
+// NewSerializerRegistry creates a registry that encodes with def and
+// decodes using whichever Serializer was Register'd for the inbound
+// ContentType.
+func NewSerializerRegistry(def Serializer) *SerializerRegistry {
+	return &SerializerRegistry{Default: def, byType: make(map[string]Serializer)}
+}
+
+// Register associates a Serializer with the ContentType it advertises,
+// so deliveries tagged with that ContentType decode with it.
+func (s *SerializerRegistry) Register(ser Serializer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byType[ser.ContentType()] = ser
+}
+
+func (s *SerializerRegistry) ContentType() string {
+	return s.Default.ContentType()
+}
+
+func (s *SerializerRegistry) Encode(in interface{}) ([]byte, error) {
+	return s.Default.Encode(in)
+}
+
+func (s *SerializerRegistry) Decode(in []byte, out interface{}) error {
+	return s.Default.Decode(in, out)
+}
+
+// decodeContentType decodes in using the Serializer registered for
+// contentType, falling back to Default if none is registered.
+func (s *SerializerRegistry) decodeContentType(contentType string, in []byte, out interface{}) error {
+	s.mu.RLock()
+	ser, ok := s.byType[contentType]
+	s.mu.RUnlock()
+	if !ok {
+		ser = s.Default
+	}
+	return ser.Decode(in, out)
+}
+
+// decodeDelivery decodes d.Body into out, dispatching on d.ContentType
+// when ser is a *SerializerRegistry so heterogeneous producers can share
+// a queue.
+func decodeDelivery(ser Serializer, d amqp.Delivery, out interface{}) error {
+	if reg, ok := ser.(*SerializerRegistry); ok {
+		return reg.decodeContentType(d.ContentType, d.Body, out)
+	}
+	return ser.Decode(d.Body, out)
+}
@@ -0,0 +1,101 @@
+package relay
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestGOBSerializerRoundTrip(t *testing.T) {
+	s := &GOBSerializer{}
+	if ct := s.ContentType(); ct != "application/x-gob" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	body, err := s.Encode("the quick brown fox")
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	var out string
+	if err := s.Decode(body, &out); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if out != "the quick brown fox" {
+		t.Fatalf("unexpected round trip result: %q", out)
+	}
+}
+
+// stringSerializer is a minimal Serializer used to exercise
+// SerializerRegistry's content-type dispatch without needing a real
+// encoding format.
+type stringSerializer struct {
+	contentType string
+	prefix      string
+}
+
+func (s *stringSerializer) ContentType() string { return s.contentType }
+
+func (s *stringSerializer) Encode(in interface{}) ([]byte, error) {
+	return []byte(s.prefix + in.(string)), nil
+}
+
+func (s *stringSerializer) Decode(in []byte, out interface{}) error {
+	*out.(*string) = s.prefix + string(in)
+	return nil
+}
+
+func TestSerializerRegistryDispatchesByContentType(t *testing.T) {
+	def := &stringSerializer{contentType: "application/x-default", prefix: "default:"}
+	alt := &stringSerializer{contentType: "application/x-alt", prefix: "alt:"}
+
+	reg := NewSerializerRegistry(def)
+	reg.Register(alt)
+
+	if ct := reg.ContentType(); ct != def.ContentType() {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	var out string
+	if err := reg.decodeContentType(alt.ContentType(), []byte("msg"), &out); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if out != "alt:msg" {
+		t.Fatalf("expected dispatch to alt serializer, got %q", out)
+	}
+
+	out = ""
+	if err := reg.decodeContentType("application/x-unregistered", []byte("msg"), &out); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if out != "default:msg" {
+		t.Fatalf("expected fallback to default serializer, got %q", out)
+	}
+}
+
+func TestDecodeDeliveryDispatchesForRegistry(t *testing.T) {
+	def := &stringSerializer{contentType: "application/x-default", prefix: "default:"}
+	alt := &stringSerializer{contentType: "application/x-alt", prefix: "alt:"}
+
+	reg := NewSerializerRegistry(def)
+	reg.Register(alt)
+
+	var out string
+	d := amqp.Delivery{ContentType: alt.ContentType(), Body: []byte("msg")}
+	if err := decodeDelivery(reg, d, &out); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if out != "alt:msg" {
+		t.Fatalf("expected dispatch to alt serializer, got %q", out)
+	}
+
+	// A plain Serializer (not a *SerializerRegistry) always decodes with
+	// itself, ignoring ContentType.
+	out = ""
+	if err := decodeDelivery(def, d, &out); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if out != "default:msg" {
+		t.Fatalf("expected plain serializer to decode directly, got %q", out)
+	}
+}
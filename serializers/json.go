@@ -0,0 +1,20 @@
+// Package serializers provides relay.Serializer implementations beyond
+// the GOB default, for interop with non-Go producers and consumers.
+package serializers
+
+import "encoding/json"
+
+// JSONSerializer serializes messages using encoding/json.
+type JSONSerializer struct{}
+
+func (j *JSONSerializer) ContentType() string {
+	return "application/json"
+}
+
+func (j *JSONSerializer) Encode(in interface{}) ([]byte, error) {
+	return json.Marshal(in)
+}
+
+func (j *JSONSerializer) Decode(in []byte, out interface{}) error {
+	return json.Unmarshal(in, out)
+}
@@ -0,0 +1,24 @@
+package serializers
+
+import "testing"
+
+func TestJSONSerializerRoundTrip(t *testing.T) {
+	s := &JSONSerializer{}
+	if ct := s.ContentType(); ct != "application/json" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	in := map[string]interface{}{"foo": "bar", "n": float64(42)}
+	body, err := s.Encode(in)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	var out map[string]interface{}
+	if err := s.Decode(body, &out); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if out["foo"] != in["foo"] || out["n"] != in["n"] {
+		t.Fatalf("unexpected round trip result: %#v", out)
+	}
+}
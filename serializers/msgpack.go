@@ -0,0 +1,18 @@
+package serializers
+
+import "github.com/vmihailenco/msgpack/v5"
+
+// MsgpackSerializer serializes messages using MessagePack.
+type MsgpackSerializer struct{}
+
+func (m *MsgpackSerializer) ContentType() string {
+	return "application/x-msgpack"
+}
+
+func (m *MsgpackSerializer) Encode(in interface{}) ([]byte, error) {
+	return msgpack.Marshal(in)
+}
+
+func (m *MsgpackSerializer) Decode(in []byte, out interface{}) error {
+	return msgpack.Unmarshal(in, out)
+}
@@ -0,0 +1,29 @@
+package serializers
+
+import "testing"
+
+func TestMsgpackSerializerRoundTrip(t *testing.T) {
+	s := &MsgpackSerializer{}
+	if ct := s.ContentType(); ct != "application/x-msgpack" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	in := []string{"the", "quick", "brown", "fox"}
+	body, err := s.Encode(in)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	var out []string
+	if err := s.Decode(body, &out); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if len(out) != len(in) {
+		t.Fatalf("unexpected round trip result: %#v", out)
+	}
+	for i := range in {
+		if out[i] != in[i] {
+			t.Fatalf("unexpected round trip result: %#v", out)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package serializers
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ProtobufSerializer serializes messages using Protocol Buffers. The
+// values passed to Encode and Decode must implement proto.Message.
+type ProtobufSerializer struct{}
+
+func (p *ProtobufSerializer) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (p *ProtobufSerializer) Encode(in interface{}) ([]byte, error) {
+	msg, ok := in.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("ProtobufSerializer requires a proto.Message, got %T", in)
+	}
+	return proto.Marshal(msg)
+}
+
+func (p *ProtobufSerializer) Decode(in []byte, out interface{}) error {
+	msg, ok := out.(proto.Message)
+	if !ok {
+		return fmt.Errorf("ProtobufSerializer requires a proto.Message, got %T", out)
+	}
+	return proto.Unmarshal(in, msg)
+}
@@ -0,0 +1,38 @@
+package serializers
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProtobufSerializerRoundTrip(t *testing.T) {
+	s := &ProtobufSerializer{}
+	if ct := s.ContentType(); ct != "application/x-protobuf" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	in := wrapperspb.String("the quick brown fox")
+	body, err := s.Encode(in)
+	if err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+
+	out := &wrapperspb.StringValue{}
+	if err := s.Decode(body, out); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	if out.Value != in.Value {
+		t.Fatalf("unexpected round trip result: %#v", out)
+	}
+}
+
+func TestProtobufSerializerRequiresProtoMessage(t *testing.T) {
+	s := &ProtobufSerializer{}
+	if _, err := s.Encode("not a proto.Message"); err == nil {
+		t.Fatalf("expected err")
+	}
+	if err := s.Decode([]byte("x"), "not a proto.Message"); err == nil {
+		t.Fatalf("expected err")
+	}
+}
@@ -0,0 +1,25 @@
+package relay
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// chanCounter is used to generate unique consumer tags
+var chanCounter uint64
+
+// queueName returns the fully qualified name of a queue
+func queueName(queue string) string {
+	return queue
+}
+
+// channelName generates a unique consumer tag for a new consumer
+func channelName() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get hostname! Got: %s", err)
+	}
+	id := atomic.AddUint64(&chanCounter, 1)
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), id), nil
+}